@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+)
+
+// fastKind classifies a field's Go type for the hand-rolled decode path.
+// Anything else falls back to marshal.Unmarshal on the field's own slice
+// of the input, which keeps the generator simple while still letting
+// every field type (slices, maps, nested structs) round-trip correctly.
+func fastKind(t string) string {
+	switch t {
+	case "string", "bool",
+		"int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64",
+		"float32", "float64":
+		return t
+	}
+	return ""
+}
+
+type templateField struct {
+	fieldInfo
+	FastKind string
+}
+
+type templateStruct struct {
+	Name       string
+	Fields     []templateField
+	ExtrasName string
+}
+
+func generate(w io.Writer, pkgName string, structs []structInfo) error {
+	data := struct {
+		Package string
+		Structs []templateStruct
+	}{Package: pkgName}
+
+	for _, s := range structs {
+		ts := templateStruct{Name: s.Name}
+		for _, f := range s.Fields {
+			if f.Extras {
+				ts.ExtrasName = f.GoName
+				continue
+			}
+			ts.Fields = append(ts.Fields, templateField{fieldInfo: f, FastKind: fastKind(f.Type)})
+		}
+		data.Structs = append(data.Structs, ts)
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+var tmpl = template.Must(template.New("gorailsgen").Parse(strings.TrimLeft(`
+// Code generated by cmd/gorailsgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"github.com/qnighy/gorails/marshal"
+)
+{{range .Structs}}
+// DecodeMarshal populates v by parsing data directly out of a Ruby
+// Marshal 4.8 payload, without allocating a marshal.MarshalledObject for
+// every node in the tree.
+func (v *{{.Name}}) DecodeMarshal(data []byte) error {
+	if len(data) < 2 {
+		return marshal.IncompleteData
+	}
+	return v.decodeMarshalBody(data[2:], marshal.NewCache())
+}
+
+func (v *{{.Name}}) decodeMarshalBody(data []byte, cache *marshal.Cache) error {
+	if len(data) == 0 || (data[0] != '{' && data[0] != '}') {
+		return marshal.TypeMismatch
+	}
+
+	size, offset := marshal.ReadInt(data[1:])
+	offset++
+{{if .ExtrasName}}
+	v.{{.ExtrasName}} = make(map[string]interface{})
+{{end}}
+	for i := int64(0); i < size; i++ {
+		key, keySize, err := cache.ReadString(data[offset:])
+		if err != nil {
+			return err
+		}
+		offset += keySize
+
+		switch key {
+{{range .Fields}}		case {{printf "%q" .HashKey}}:
+{{if eq .FastKind "string"}}			s, sz, err := cache.ReadString(data[offset:])
+			if err != nil {
+				return err
+			}
+			v.{{.GoName}} = s
+			offset += sz
+{{else if eq .FastKind "bool"}}			if offset >= len(data) || (data[offset] != 'T' && data[offset] != 'F') {
+				return marshal.TypeMismatch
+			}
+			v.{{.GoName}} = data[offset] == 'T'
+			offset++
+{{else if eq .FastKind "float32"}}			if offset >= len(data) || data[offset] != 'f' {
+				return marshal.TypeMismatch
+			}
+			val, sz, ferr := cache.ReadValue(data[offset:])
+			if ferr != nil {
+				return ferr
+			}
+			f, ok := val.(float64)
+			if !ok {
+				return marshal.TypeMismatch
+			}
+			v.{{.GoName}} = float32(f)
+			offset += sz
+{{else if eq .FastKind "float64"}}			if offset >= len(data) || data[offset] != 'f' {
+				return marshal.TypeMismatch
+			}
+			val, sz, ferr := cache.ReadValue(data[offset:])
+			if ferr != nil {
+				return ferr
+			}
+			f, ok := val.(float64)
+			if !ok {
+				return marshal.TypeMismatch
+			}
+			v.{{.GoName}} = f
+			offset += sz
+{{else if .FastKind}}			if offset >= len(data) || data[offset] != 'i' {
+				return marshal.TypeMismatch
+			}
+			n, sz := marshal.ReadInt(data[offset+1:])
+			v.{{.GoName}} = {{.Type}}(n)
+			offset += 1 + sz
+{{else}}			sz := cache.Skip(data[offset:])
+			if err := marshal.Unmarshal(append([]byte{4, 8}, data[offset:offset+sz]...), &v.{{.GoName}}); err != nil {
+				return err
+			}
+			offset += sz
+{{end}}
+{{end}}		default:
+{{if .ExtrasName}}			val, sz, err := cache.ReadValue(data[offset:])
+			if err != nil {
+				return err
+			}
+			v.{{.ExtrasName}}[key] = val
+			offset += sz
+{{else}}			offset += cache.Skip(data[offset:])
+{{end}}		}
+	}
+
+	if data[0] == '}' {
+		offset += cache.Skip(data[offset:])
+	}
+
+	return nil
+}
+
+// EncodeMarshal defers to the reflective marshal.Marshal: the generator
+// only targets the decode hot path (e.g. reading a session on every
+// request), so encoding reuses the existing reflective Encoder.
+func (v *{{.Name}}) EncodeMarshal() ([]byte, error) {
+	return marshal.Marshal(v)
+}
+{{end}}
+`, "\n")))
@@ -0,0 +1,50 @@
+package example
+
+import (
+	"testing"
+
+	"github.com/qnighy/gorails/marshal"
+)
+
+func fixture(b *testing.B) []byte {
+	data, err := marshal.Marshal(&Session{
+		UserID:     42,
+		CSRFToken:  "abc123def456",
+		Remembered: true,
+		Extras:     map[string]interface{}{"theme": "dark"},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// BenchmarkDecodeMarshal_Generated exercises the gorailsgen fast path
+// (example_marshal.go).
+func BenchmarkDecodeMarshal_Generated(b *testing.B) {
+	data := fixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var s Session
+		if err := s.DecodeMarshal(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeMarshal_Reflection exercises the reflective
+// marshal.Unmarshal path for comparison.
+func BenchmarkDecodeMarshal_Reflection(b *testing.B) {
+	data := fixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var s Session
+		if err := marshal.Unmarshal(data, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Package example holds a fixture Rails session struct used to exercise
+// cmd/gorailsgen: session.go is the annotated input, example_marshal.go is
+// generated by running `go generate` in this directory, and bench_test.go
+// compares the two decode paths. It deliberately isn't named "testdata" —
+// that name makes the go tool skip the directory entirely, which is how
+// this package's own generated output went stale undetected.
+package example
+
+//go:generate gorailsgen
+
+// Session mirrors the shape of a typical Rails session cookie: a handful
+// of known keys plus an open-ended bag of anything else the application
+// stashed in `session[...]`.
+type Session struct {
+	UserID     int                    `marshal:"user_id"`
+	CSRFToken  string                 `marshal:"_csrf_token"`
+	Remembered bool                   `marshal:"remember_me,omitempty"`
+	Extras     map[string]interface{} `marshal:",extras"`
+}
@@ -0,0 +1,53 @@
+// Command gorailsgen generates hand-rolled Ruby-Marshal decoders for
+// annotated structs, bypassing the reflective marshal.Unmarshal path. The
+// generated EncodeMarshal method is not hand-rolled: it calls the
+// reflective marshal.Marshal. It follows the workflow popularized by
+// tinylib/msgp: annotate a struct with a `//go:generate gorailsgen` (or
+// `// marshal:generate`) directive, then run the tool over the containing
+// package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default: <dir>/<pkg>_marshal.go)")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := run(dir, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "gorailsgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out string) error {
+	pkgName, structs, err := scanDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(structs) == 0 {
+		return fmt.Errorf("no //go:generate gorailsgen structs found in %s", dir)
+	}
+
+	if out == "" {
+		out = filepath.Join(dir, pkgName+"_marshal.go")
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return generate(f, pkgName, structs)
+}
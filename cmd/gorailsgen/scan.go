@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// fieldInfo mirrors the struct-tag conventions understood by
+// marshal.Unmarshal: a `marshal:"name,omitempty"` tag renames the Ruby
+// hash key and/or skips zero values on encode, and `marshal:",extras"`
+// designates a map[string]T catch-all for unrecognized keys.
+type fieldInfo struct {
+	GoName    string
+	Type      string
+	HashKey   string
+	OmitEmpty bool
+	Extras    bool
+}
+
+type structInfo struct {
+	Name   string
+	Fields []fieldInfo
+}
+
+// scanDir parses every non-generated, non-test .go file in dir and returns
+// its package name together with every struct annotated with
+// `//go:generate gorailsgen` or `// marshal:generate`.
+func scanDir(dir string) (pkgName string, structs []structInfo, err error) {
+	fset := token.NewFileSet()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, path := range matches {
+		if strings.HasSuffix(path, "_test.go") || strings.HasSuffix(path, "_marshal.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return "", nil, err
+		}
+		pkgName = file.Name.Name
+
+		// ast.NewCommentMap associates each comment group with the
+		// nearest following node even across a blank line, unlike
+		// gen.Doc/ts.Doc (which go/ast only populates from a
+		// contiguous, blank-line-free block) — needed since a
+		// standalone `//go:generate gorailsgen` directive is commonly
+		// followed by a blank line and then a separate doc-comment
+		// paragraph.
+		cmap := ast.NewCommentMap(fset, file, file.Comments)
+
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range gen.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+
+				if !annotated(cmap[gen]) && !annotated(cmap[ts]) {
+					continue
+				}
+
+				fields, err := structFields(fset, st)
+				if err != nil {
+					return "", nil, err
+				}
+
+				structs = append(structs, structInfo{Name: ts.Name.Name, Fields: fields})
+			}
+		}
+	}
+
+	return pkgName, structs, nil
+}
+
+func annotated(groups []*ast.CommentGroup) bool {
+	for _, doc := range groups {
+		for _, c := range doc.List {
+			text := strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), " ")
+			if strings.HasPrefix(text, "go:generate gorailsgen") || strings.HasPrefix(text, "marshal:generate") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func structFields(fset *token.FileSet, st *ast.StructType) ([]fieldInfo, error) {
+	var fields []fieldInfo
+
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			// Embedded fields aren't supported by the fast path.
+			continue
+		}
+
+		typeStr, err := exprString(fset, f.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		tag := ""
+		if f.Tag != nil {
+			tag = reflectStructTag(f.Tag.Value).Get("marshal")
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				continue
+			}
+
+			info := fieldInfo{GoName: name.Name, Type: typeStr, HashKey: name.Name}
+
+			if tag != "" {
+				parts := strings.Split(tag, ",")
+				if parts[0] != "" {
+					info.HashKey = parts[0]
+				}
+				for _, opt := range parts[1:] {
+					switch opt {
+					case "omitempty":
+						info.OmitEmpty = true
+					case "extras":
+						info.Extras = true
+					}
+				}
+			}
+
+			fields = append(fields, info)
+		}
+	}
+
+	return fields, nil
+}
+
+func exprString(fset *token.FileSet, expr ast.Expr) (string, error) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, expr); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// reflectStructTag is a tiny stand-in for reflect.StructTag.Get that works
+// directly on the raw, still-quoted tag literal from the AST.
+type reflectStructTag string
+
+func (t reflectStructTag) Get(key string) string {
+	raw := strings.Trim(string(t), "`")
+	for raw != "" {
+		i := strings.IndexByte(raw, ' ')
+		var pair string
+		if i < 0 {
+			pair, raw = raw, ""
+		} else {
+			pair, raw = raw[:i], strings.TrimLeft(raw[i+1:], " ")
+		}
+
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || kv[0] != key {
+			continue
+		}
+
+		value := strings.Trim(kv[1], `"`)
+		return value
+	}
+
+	return ""
+}
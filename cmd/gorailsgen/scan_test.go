@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanDirSkipsDashTag(t *testing.T) {
+	dir := t.TempDir()
+	src := `package fixture
+
+//go:generate gorailsgen
+type Secret struct {
+	Visible string ` + "`marshal:\"visible\"`" + `
+	Hidden  string ` + "`marshal:\"-\"`" + `
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "secret.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, structs, err := scanDir(dir)
+	if err != nil {
+		t.Fatalf("scanDir() error = %v", err)
+	}
+	if len(structs) != 1 {
+		t.Fatalf("scanDir() found %d structs, want 1", len(structs))
+	}
+
+	fields := structs[0].Fields
+	for _, f := range fields {
+		if f.GoName == "Hidden" {
+			t.Fatalf("structFields() kept a field tagged marshal:\"-\": %+v", fields)
+		}
+	}
+	if len(fields) != 1 || fields[0].GoName != "Visible" {
+		t.Errorf("structFields() = %+v, want only Visible", fields)
+	}
+}
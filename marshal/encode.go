@@ -0,0 +1,325 @@
+package marshal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// Symbol is a string that marshals as a Ruby Symbol (e.g. `:user_id`)
+// rather than a String.
+type Symbol string
+
+var UnsupportedType = errors.New("gorails/marshal: unsupported type for Marshal")
+
+// Marshal returns the Ruby Marshal 4.8 encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encoder writes Ruby-Marshal-encoded values to an output stream.
+type Encoder struct {
+	w io.Writer
+
+	symbolCache map[string]int64
+	stringCache map[string]int64
+	floatCache  map[float64]int64
+	sliceCache  map[uintptr]int64
+	mapCache    map[uintptr]int64
+	linkCount   int64
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:           w,
+		symbolCache: make(map[string]int64),
+		stringCache: make(map[string]int64),
+		floatCache:  make(map[float64]int64),
+		sliceCache:  make(map[uintptr]int64),
+		mapCache:    make(map[uintptr]int64),
+	}
+}
+
+// Encode writes the Ruby Marshal 4.8 encoding of v, including the version
+// header.
+func (enc *Encoder) Encode(v interface{}) error {
+	if _, err := enc.w.Write([]byte{4, 8}); err != nil {
+		return err
+	}
+
+	return enc.encodeValue(reflect.ValueOf(v))
+}
+
+func (enc *Encoder) write(p []byte) error {
+	_, err := enc.w.Write(p)
+	return err
+}
+
+func (enc *Encoder) encodeValue(rv reflect.Value) error {
+	if !rv.IsValid() {
+		return enc.writeNil()
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return enc.writeNil()
+		}
+		return enc.encodeValue(rv.Elem())
+	case reflect.Bool:
+		return enc.writeBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return enc.writeInteger(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return enc.writeInteger(int64(rv.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return enc.writeFloat(rv.Float())
+	case reflect.String:
+		if rv.Type() == reflect.TypeOf(Symbol("")) {
+			return enc.writeSymbol(rv.String())
+		}
+		return enc.writeString(rv.String())
+	case reflect.Slice, reflect.Array:
+		return enc.writeArray(rv)
+	case reflect.Map:
+		return enc.writeMap(rv)
+	case reflect.Struct:
+		return enc.writeStruct(rv)
+	}
+
+	return UnsupportedType
+}
+
+func (enc *Encoder) writeNil() error {
+	return enc.write([]byte{'0'})
+}
+
+func (enc *Encoder) writeBool(v bool) error {
+	if v {
+		return enc.write([]byte{'T'})
+	}
+	return enc.write([]byte{'F'})
+}
+
+func (enc *Encoder) writeInteger(v int64) error {
+	return enc.write(append([]byte{'i'}, encodeInt(v)...))
+}
+
+// writeFloat emits v as a Ruby Float, reusing the object back-reference
+// table when v has already been written — real Ruby Marshal allocates a
+// link-table slot for every Float the same as it does for String/Array/
+// Map, since Floats are heap objects, not Fixnum-style immediates.
+func (enc *Encoder) writeFloat(v float64) error {
+	if idx, ok := enc.floatCache[v]; ok {
+		return enc.writeLink(idx)
+	}
+	enc.link()
+	enc.floatCache[v] = enc.linkCount - 1
+
+	s := floatToRubyString(v)
+	return enc.write(append([]byte{'f'}, encodeRawString(s)...))
+}
+
+// writeSymbol emits v as a Ruby Symbol, reusing the symbol back-reference
+// table when v has already been written.
+func (enc *Encoder) writeSymbol(v string) error {
+	if idx, ok := enc.symbolCache[v]; ok {
+		return enc.write(append([]byte{';'}, encodeInt(idx)...))
+	}
+
+	enc.symbolCache[v] = int64(len(enc.symbolCache))
+
+	return enc.write(append([]byte{':'}, encodeRawString(v)...))
+}
+
+// writeString emits v as a Ruby String carrying a UTF-8 encoding ivar,
+// reusing the object back-reference table when v has already been written.
+// The ivar's :E name goes through the symbol table like any other Symbol.
+func (enc *Encoder) writeString(v string) error {
+	if idx, ok := enc.stringCache[v]; ok {
+		return enc.writeLink(idx)
+	}
+	enc.link()
+	enc.stringCache[v] = enc.linkCount - 1
+
+	out := append([]byte{'I', '"'}, encodeRawString(v)...)
+	out = append(out, encodeInt(1)...)
+	if err := enc.write(out); err != nil {
+		return err
+	}
+	if err := enc.writeSymbol("E"); err != nil {
+		return err
+	}
+
+	return enc.write([]byte{'T'})
+}
+
+func (enc *Encoder) writeArray(rv reflect.Value) error {
+	if rv.Kind() == reflect.Slice {
+		if idx, ok := enc.sliceCache[rv.Pointer()]; ok {
+			return enc.writeLink(idx)
+		}
+		enc.link()
+		enc.sliceCache[rv.Pointer()] = enc.linkCount - 1
+	} else {
+		enc.link()
+	}
+
+	if err := enc.write(append([]byte{'['}, encodeInt(int64(rv.Len()))...)); err != nil {
+		return err
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.encodeValue(rv.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (enc *Encoder) writeMap(rv reflect.Value) error {
+	if idx, ok := enc.mapCache[rv.Pointer()]; ok {
+		return enc.writeLink(idx)
+	}
+	enc.link()
+	enc.mapCache[rv.Pointer()] = enc.linkCount - 1
+
+	if err := enc.write(append([]byte{'{'}, encodeInt(int64(rv.Len()))...)); err != nil {
+		return err
+	}
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		if err := enc.encodeValue(iter.Key()); err != nil {
+			return err
+		}
+		if err := enc.encodeValue(iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (enc *Encoder) writeStruct(rv reflect.Value) error {
+	enc.link()
+
+	fields, extrasField := structFields(rv.Type())
+
+	var included []fieldInfo
+	for _, f := range fields {
+		if f.omitempty && rv.Field(f.index).IsZero() {
+			continue
+		}
+		included = append(included, f)
+	}
+
+	size := int64(len(included))
+
+	var extras reflect.Value
+	if extrasField >= 0 {
+		extras = rv.Field(extrasField)
+		size += int64(extras.Len())
+	}
+
+	if err := enc.write(append([]byte{'{'}, encodeInt(size)...)); err != nil {
+		return err
+	}
+
+	for _, f := range included {
+		if err := enc.writeSymbol(f.name); err != nil {
+			return err
+		}
+		if err := enc.encodeValue(rv.Field(f.index)); err != nil {
+			return err
+		}
+	}
+
+	if extrasField >= 0 {
+		iter := extras.MapRange()
+		for iter.Next() {
+			if err := enc.writeSymbol(iter.Key().String()); err != nil {
+				return err
+			}
+			if err := enc.encodeValue(iter.Value()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (enc *Encoder) link() {
+	enc.linkCount++
+}
+
+func (enc *Encoder) writeLink(idx int64) error {
+	return enc.write(append([]byte{'@'}, encodeInt(idx)...))
+}
+
+// encodeInt is the inverse of parseInt: it renders n using the same
+// variable-length integer encoding Ruby Marshal uses.
+func encodeInt(n int64) []byte {
+	switch {
+	case n == 0:
+		return []byte{0}
+	case n > 0 && n < 123:
+		return []byte{byte(n + 5)}
+	case n < 0 && n > -124:
+		return []byte{byte(n - 5)}
+	case n > 0:
+		bs := littleEndianBytes(uint64(n))
+		return append([]byte{byte(len(bs))}, bs...)
+	default:
+		bs := littleEndianBytes(uint64(-n - 1))
+		out := make([]byte, len(bs))
+		for i, b := range bs {
+			out[i] = 0xff - b
+		}
+		return append([]byte{byte(256 - len(bs))}, out...)
+	}
+}
+
+func littleEndianBytes(v uint64) []byte {
+	var bs []byte
+	for v != 0 {
+		bs = append(bs, byte(v&0xff))
+		v >>= 8
+	}
+	if len(bs) == 0 {
+		bs = []byte{0}
+	}
+	return bs
+}
+
+// encodeRawString renders a length-prefixed byte string as used for Symbol
+// payloads, String payloads (without the encoding envelope), and Float
+// textual values.
+func encodeRawString(s string) []byte {
+	return append(encodeInt(int64(len(s))), []byte(s)...)
+}
+
+func floatToRubyString(v float64) string {
+	switch {
+	case v != v:
+		return "nan"
+	case v > maxRubyFloat:
+		return "inf"
+	case v < -maxRubyFloat:
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+const maxRubyFloat = 1.7976931348623157e+308
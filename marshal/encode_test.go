@@ -0,0 +1,171 @@
+package marshal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalScalars(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want []byte
+	}{
+		{"nil", nil, fixtureNil},
+		{"true", true, fixtureTrue},
+		{"false", false, fixtureFalse},
+		{"int", 1000, fixtureInt1000},
+		{"float", 1.5, fixtureFloat},
+		{"symbol", Symbol("foo"), fixtureSymbol},
+		{"string", "foo", fixtureString},
+		{"array", []int{1, 2, 3}, fixtureArray},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Marshal(c.in)
+			if err != nil {
+				t.Fatalf("Marshal(%#v) error = %v", c.in, err)
+			}
+			if !bytes.Equal(got, c.want) {
+				t.Errorf("Marshal(%#v) = %#v, want %#v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMarshalMap(t *testing.T) {
+	got, err := Marshal(map[Symbol]int{"a": 1})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !bytes.Equal(got, fixtureHash) {
+		t.Errorf("Marshal() = %#v, want %#v", got, fixtureHash)
+	}
+}
+
+func TestMarshalStruct(t *testing.T) {
+	type Session struct {
+		UserID int                    `marshal:"user_id"`
+		Extras map[string]interface{} `marshal:",extras"`
+	}
+
+	data, err := Marshal(&Session{UserID: 42, Extras: map[string]interface{}{}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var out Session
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if out.UserID != 42 {
+		t.Errorf("UserID = %d, want 42", out.UserID)
+	}
+}
+
+// TestMarshalStructOmitsDashTag mirrors cmd/gorailsgen's scan_test.go: a
+// field tagged `marshal:"-"` must never reach the wire.
+func TestMarshalStructOmitsDashTag(t *testing.T) {
+	type Secret struct {
+		Visible string `marshal:"visible"`
+		Hidden  string `marshal:"-"`
+	}
+
+	data, err := Marshal(&Secret{Visible: "ok", Hidden: "nope"})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if bytes.Contains(data, []byte("nope")) {
+		t.Errorf("Marshal() leaked a field tagged marshal:\"-\": %#v", data)
+	}
+}
+
+func TestMarshalStringCaching(t *testing.T) {
+	got, err := Marshal([]string{"c", "c"})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	// Round-trip rather than asserting exact bytes: the cache only
+	// kicks in for strings, not symbols, so the link offset differs
+	// from fixtureSymbolLink.
+	var out []string
+	if err := Unmarshal(got, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(out) != 2 || out[0] != "c" || out[1] != "c" {
+		t.Errorf("round-trip = %#v, want [c c]", out)
+	}
+}
+
+func TestMarshalFloatCaching(t *testing.T) {
+	got, err := Marshal([]float64{1.5, 1.5})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	// Round-trip rather than asserting exact bytes: what matters is that
+	// the second 1.5 is written as a link back to the first rather than
+	// as its own Float, like real Ruby Marshal does for repeated Floats.
+	var out []float64
+	if err := Unmarshal(got, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(out) != 2 || out[0] != 1.5 || out[1] != 1.5 {
+		t.Errorf("round-trip = %#v, want [1.5 1.5]", out)
+	}
+	if !bytes.HasSuffix(got, []byte{'@', 6}) {
+		t.Errorf("Marshal() = %#v, want the second 1.5 written as link @1", got)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	type Inner struct {
+		Name string `marshal:"name"`
+	}
+	type Outer struct {
+		ID     int64                  `marshal:"id"`
+		Float  float64                `marshal:"float"`
+		Flag   bool                   `marshal:"flag,omitempty"`
+		Tags   []string               `marshal:"tags"`
+		Inner  Inner                  `marshal:"inner"`
+		Lookup map[string]int         `marshal:"lookup"`
+		Extras map[string]interface{} `marshal:",extras"`
+	}
+
+	in := &Outer{
+		ID:     7,
+		Float:  2.25,
+		Tags:   []string{"a", "b"},
+		Inner:  Inner{Name: "leaf"},
+		Lookup: map[string]int{"x": 1},
+		Extras: map[string]interface{}{"extra": "value"},
+	}
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var out Outer
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if out.ID != in.ID || out.Float != in.Float || out.Flag != in.Flag {
+		t.Errorf("scalar fields = %+v, want %+v", out, in)
+	}
+	if len(out.Tags) != 2 || out.Tags[0] != "a" || out.Tags[1] != "b" {
+		t.Errorf("Tags = %#v, want [a b]", out.Tags)
+	}
+	if out.Inner.Name != "leaf" {
+		t.Errorf("Inner.Name = %q, want leaf", out.Inner.Name)
+	}
+	if out.Lookup["x"] != 1 {
+		t.Errorf("Lookup[x] = %d, want 1", out.Lookup["x"])
+	}
+	if out.Extras["extra"] != "value" {
+		t.Errorf("Extras[extra] = %v, want value", out.Extras["extra"])
+	}
+}
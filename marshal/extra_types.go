@@ -0,0 +1,271 @@
+package marshal
+
+import (
+	"math/big"
+	"sync"
+)
+
+// GetAsBignum returns the value of a Ruby Bignum.
+func (obj *MarshalledObject) GetAsBignum() (value *big.Int, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsBignum()
+	}
+
+	err = assertType(obj, TypeBignum)
+	if err != nil {
+		return
+	}
+
+	if len(obj.data) < 3 {
+		err = IncompleteData
+		return
+	}
+
+	sign := obj.data[1]
+
+	// Bounds-check the word-count header ourselves before handing it to
+	// parseInt, which assumes its caller already verified enough bytes
+	// follow the header byte.
+	var countHeaderSize int
+	switch countHeader := obj.data[2]; {
+	case countHeader > 0x05 && countHeader < 0xfb:
+		countHeaderSize = 0
+	case countHeader <= 0x05:
+		countHeaderSize = int(countHeader)
+	default:
+		countHeaderSize = int(0xff-countHeader) + 1
+	}
+	if len(obj.data) < 3+countHeaderSize {
+		err = IncompleteData
+		return
+	}
+
+	obj.cacheObject(obj)
+
+	words, offset := parseInt(obj.data[2:])
+	offset += 2
+
+	n := int(words) * 2
+	if n < 0 || offset+n > len(obj.data) {
+		err = IncompleteData
+		return
+	}
+	littleEndian := obj.data[offset : offset+n]
+
+	bigEndian := make([]byte, n)
+	for i, b := range littleEndian {
+		bigEndian[n-1-i] = b
+	}
+
+	value = new(big.Int).SetBytes(bigEndian)
+	if sign == '-' {
+		value.Neg(value)
+	}
+
+	return
+}
+
+// Regexp is what a Ruby Regexp ('/') decodes to when Unmarshal has
+// nothing more specific to put it in, e.g. an `interface{}` or `,extras`
+// field. Source and Flags mirror GetAsRegexp's return values.
+type Regexp struct {
+	Source string
+	Flags  int
+}
+
+// GetAsRegexp returns the source pattern and the raw Onigmo option flags
+// (Regexp::IGNORECASE, Regexp::EXTENDED, Regexp::MULTILINE) of a Ruby
+// Regexp.
+func (obj *MarshalledObject) GetAsRegexp() (pattern string, flags int, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsRegexp()
+	}
+
+	err = assertType(obj, TypeRegexp)
+	if err != nil {
+		return
+	}
+
+	obj.cacheObject(obj)
+
+	var size int
+	pattern, size = parseString(obj.data[1:])
+	flags = int(obj.data[1+size])
+
+	return
+}
+
+// GetAsObject returns the class name and instance variables of a Ruby
+// object ('o') or Struct ('S'). Instance variable names have their
+// leading '@' stripped.
+func (obj *MarshalledObject) GetAsObject() (className string, ivars map[string]*MarshalledObject, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsObject()
+	}
+
+	err = assertType(obj, TypeObject)
+	if err != nil {
+		return
+	}
+
+	obj.cacheObject(obj)
+
+	nameObj := newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[1:], obj.symbolCache, obj.objectCache)
+	className, err = nameObj.GetAsString()
+	if err != nil {
+		return
+	}
+	offset := 1 + nameObj.getSize()
+
+	count, size := parseInt(obj.data[offset:])
+	offset += size
+
+	ivars = make(map[string]*MarshalledObject, count)
+	for i := int64(0); i < count; i++ {
+		key := newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[offset:], obj.symbolCache, obj.objectCache)
+		keyName, kerr := key.GetAsString()
+		if kerr != nil {
+			err = kerr
+			return
+		}
+		offset += key.getSize()
+
+		valueSize := newMarshalledObjectWithSize(obj.MajorVersion, obj.MinorVersion, obj.data[offset:], 0, obj.symbolCache, obj.objectCache).getSize()
+		val := newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[offset:offset+valueSize], obj.symbolCache, obj.objectCache)
+		obj.cacheObject(val)
+		offset += valueSize
+
+		ivars[trimIvarPrefix(keyName)] = val
+	}
+
+	obj.size = offset
+
+	return
+}
+
+// Class is a bare Ruby class ('c') reference decoded into an
+// `interface{}` or `,extras` field, distinguished from a plain string by
+// its type the same way Symbol is.
+type Class string
+
+// Module is a bare Ruby module ('m') reference decoded into an
+// `interface{}` or `,extras` field, distinguished from a plain string by
+// its type the same way Symbol is.
+type Module string
+
+// GetAsClassName returns the name of a bare Ruby class ('c') or module
+// ('m') reference, as used e.g. for a Struct's class in a serialized
+// ActiveRecord association.
+func (obj *MarshalledObject) GetAsClassName() (name string, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsClassName()
+	}
+
+	t := obj.GetType()
+	if t != TypeClass && t != TypeModule {
+		err = TypeMismatch
+		return
+	}
+
+	if len(obj.data) < 2 {
+		err = IncompleteData
+		return
+	}
+
+	obj.cacheObject(obj)
+
+	name, _ = parseString(obj.data[1:])
+	return
+}
+
+// trimIvarPrefix strips the leading '@' Ruby uses for instance
+// variable names (e.g. "@user_id" -> "user_id").
+func trimIvarPrefix(name string) string {
+	if len(name) > 0 && name[0] == '@' {
+		return name[1:]
+	}
+	return name
+}
+
+// UserDefined is what a value dumped via a custom `_dump`/`marshal_dump`
+// method ('u'/'U') decodes to when Unmarshal has nothing more specific to
+// put it in, e.g. an `interface{}` or `,extras` field.
+type UserDefined struct {
+	ClassName string
+	Payload   []byte
+}
+
+// GetAsUserDefined returns the class name and opaque payload of an object
+// dumped via a custom `_dump`/`marshal_dump` method ('u'/'U').
+func (obj *MarshalledObject) GetAsUserDefined() (className string, payload []byte, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsUserDefined()
+	}
+
+	err = assertType(obj, TypeUserDefined)
+	if err != nil {
+		return
+	}
+
+	obj.cacheObject(obj)
+
+	nameObj := newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[1:], obj.symbolCache, obj.objectCache)
+	className, err = nameObj.GetAsString()
+	if err != nil {
+		return
+	}
+	offset := 1 + nameObj.getSize()
+
+	if obj.data[0] == 'u' {
+		length, size := parseInt(obj.data[offset:])
+		offset += size
+		payload = obj.data[offset : offset+int(length)]
+		offset += int(length)
+	} else {
+		// 'U': marshal_dump returned an ordinary Marshal-encoded value.
+		size := newMarshalledObjectWithSize(obj.MajorVersion, obj.MinorVersion, obj.data[offset:], 0, obj.symbolCache, obj.objectCache).getSize()
+		payload = obj.data[offset : offset+size]
+		offset += size
+	}
+
+	obj.size = offset
+
+	return
+}
+
+// unwrapExtended strips the 'e' tag and the extending module's symbol off
+// an extended-object payload, returning the wrapped object underneath.
+func (obj *MarshalledObject) unwrapExtended() *MarshalledObject {
+	sym := newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[1:], obj.symbolCache, obj.objectCache)
+	symSize := sym.getSize()
+
+	return newMarshalledObject(obj.MajorVersion, obj.MinorVersion, obj.data[1+symSize:], obj.symbolCache, obj.objectCache)
+}
+
+// ClassFactory builds a Go value for a Ruby object of the given class
+// from its decoded instance variables. It's invoked by Unmarshal when
+// decoding into an interface{} and a matching class was registered via
+// RegisterClass.
+type ClassFactory func(className string, ivars map[string]*MarshalledObject) (interface{}, error)
+
+var classRegistry = struct {
+	mu sync.RWMutex
+	m  map[string]ClassFactory
+}{m: make(map[string]ClassFactory)}
+
+// RegisterClass associates a Ruby class name (e.g.
+// "ActiveSupport::TimeWithZone") with a factory used to decode objects of
+// that class into a richer Go representation than the generic
+// className/ivars pair GetAsObject returns.
+func RegisterClass(name string, factory ClassFactory) {
+	classRegistry.mu.Lock()
+	defer classRegistry.mu.Unlock()
+	classRegistry.m[name] = factory
+}
+
+func lookupClass(name string) (ClassFactory, bool) {
+	classRegistry.mu.RLock()
+	defer classRegistry.mu.RUnlock()
+	factory, ok := classRegistry.m[name]
+	return factory, ok
+}
@@ -0,0 +1,95 @@
+package marshal
+
+// The functions below expose the wire-level primitives used internally by
+// MarshalledObject to code generated by cmd/gorailsgen. Generated decoders
+// parse straight out of a []byte without allocating MarshalledObject
+// wrappers, so they need direct access to the same varint/string layout
+// the reflective path uses, without duplicating (and risking drifting
+// from) its implementation.
+
+// ReadInt decodes a Marshal varint at the start of data, returning the
+// value and the number of bytes it occupies.
+func ReadInt(data []byte) (int64, int) {
+	return parseInt(data)
+}
+
+// ReadBool decodes a Marshal bool ('T'/'F') at the start of data.
+func ReadBool(data []byte) (bool, int) {
+	return parseBool(data)
+}
+
+// ReadString decodes a length-prefixed byte string (as used for Symbol
+// payloads and bare string literals) at the start of data.
+func ReadString(data []byte) (string, int) {
+	return parseString(data)
+}
+
+// ReadIvarString decodes a Ruby String's `I"...` payload including its
+// encoding ivar envelope, returning any embedded symbols that should be
+// folded into the symbol cache.
+func ReadIvarString(data []byte) (value string, size int, symbols []string) {
+	return parseStringWithEncoding(data)
+}
+
+// WriteInt is the inverse of ReadInt: it renders n as a Marshal varint.
+func WriteInt(n int64) []byte {
+	return encodeInt(n)
+}
+
+// Cache tracks the symbol and object back-reference tables a single
+// Marshal stream shares across its nodes. Generated gorailsgen decoders
+// create one per call (mirroring the per-document symbolCache/objectCache
+// that CreateMarshalledObject sets up) and use it to read fields straight
+// out of the wire format without building a full MarshalledObject tree.
+type Cache struct {
+	symbols []string
+	objects []*MarshalledObject
+}
+
+// NewCache returns an empty Cache ready to decode a single document.
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+func (c *Cache) wrap(data []byte) *MarshalledObject {
+	return newMarshalledObjectWithSize(4, 8, data, 0, &c.symbols, &c.objects)
+}
+
+// ReadSymbol decodes a Ruby Symbol (`:foo` or a `;n` back-reference) at
+// the start of data.
+func (c *Cache) ReadSymbol(data []byte) (value string, size int, err error) {
+	obj := c.wrap(data)
+	value, err = obj.GetAsSymbol()
+	size = obj.getSize()
+	return
+}
+
+// ReadString decodes a Ruby String or Symbol at the start of data,
+// resolving `@n` back-references against previously read strings.
+func (c *Cache) ReadString(data []byte) (value string, size int, err error) {
+	obj := c.wrap(data)
+	value, err = obj.GetAsString()
+	size = obj.getSize()
+	return
+}
+
+// ReadValue decodes an arbitrary value at the start of data the same way
+// the reflective Unmarshal does, for use by generated "extras" catch-all
+// fields that accept any unrecognized key.
+func (c *Cache) ReadValue(data []byte) (value interface{}, size int, err error) {
+	obj := c.wrap(data)
+	value, err = decodeAny(obj, "")
+	size = obj.getSize()
+	return
+}
+
+// Skip returns the byte size of the value at the start of data without
+// decoding it, advancing the cache's back-reference tables as a side
+// effect (matching what GetAsArray/GetAsMap do when walking past a field
+// a generated decoder doesn't know about).
+func (c *Cache) Skip(data []byte) int {
+	obj := c.wrap(data)
+	size := obj.getSize()
+	obj.cacheObject(obj)
+	return size
+}
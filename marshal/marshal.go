@@ -19,16 +19,24 @@ type marshalledObjectType byte
 
 var TypeMismatch = errors.New("gorails/marshal: an attempt to implicitly typecast a marshalled object")
 var IncompleteData = errors.New("gorails/marshal: incomplete data")
+var InvalidBackReference = errors.New("gorails/marshal: back-reference index out of range")
 
 const (
-	TypeUnknown marshalledObjectType = 0
-	TypeNil     marshalledObjectType = 1
-	TypeBool    marshalledObjectType = 2
-	TypeInteger marshalledObjectType = 3
-	TypeFloat   marshalledObjectType = 4
-	TypeString  marshalledObjectType = 5
-	TypeArray   marshalledObjectType = 6
-	TypeMap     marshalledObjectType = 7
+	TypeUnknown     marshalledObjectType = 0
+	TypeNil         marshalledObjectType = 1
+	TypeBool        marshalledObjectType = 2
+	TypeInteger     marshalledObjectType = 3
+	TypeFloat       marshalledObjectType = 4
+	TypeString      marshalledObjectType = 5
+	TypeArray       marshalledObjectType = 6
+	TypeMap         marshalledObjectType = 7
+	TypeSymbol      marshalledObjectType = 8
+	TypeBignum      marshalledObjectType = 9
+	TypeObject      marshalledObjectType = 10
+	TypeRegexp      marshalledObjectType = 11
+	TypeUserDefined marshalledObjectType = 12
+	TypeClass       marshalledObjectType = 13
+	TypeModule      marshalledObjectType = 14
 )
 
 // For compatibility
@@ -76,15 +84,29 @@ func (obj *MarshalledObject) GetType() marshalledObjectType {
 	case 'f':
 		return TypeFloat
 	case ':', ';':
-		return TypeString
+		return TypeSymbol
 	case 'I':
 		if len(obj.data) > 1 && obj.data[1] == '"' {
 			return TypeString
 		}
 	case '[':
 		return TypeArray
-	case '{':
+	case '{', '}':
 		return TypeMap
+	case 'l':
+		return TypeBignum
+	case 'o', 'S':
+		return TypeObject
+	case '/':
+		return TypeRegexp
+	case 'u', 'U':
+		return TypeUserDefined
+	case 'c':
+		return TypeClass
+	case 'm':
+		return TypeModule
+	case 'e':
+		return obj.unwrapExtended().GetType()
 	}
 
 	return TypeUnknown
@@ -113,11 +135,17 @@ func (obj *MarshalledObject) GetAsInteger() (value int64, err error) {
 }
 
 func (obj *MarshalledObject) GetAsFloat() (value float64, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsFloat()
+	}
+
 	err = assertType(obj, TypeFloat)
 	if err != nil {
 		return
 	}
 
+	obj.cacheObject(obj)
+
 	str, _ := parseString(obj.data[1:])
 	value, err = strconv.ParseFloat(str, 64)
 
@@ -129,8 +157,9 @@ func (obj *MarshalledObject) GetAsString() (value string, err error) {
 		return ref.GetAsString()
 	}
 
-	err = assertType(obj, TypeString)
-	if err != nil {
+	t := obj.GetType()
+	if t != TypeString && t != TypeSymbol {
+		err = TypeMismatch
 		return
 	}
 
@@ -143,6 +172,10 @@ func (obj *MarshalledObject) GetAsString() (value string, err error) {
 	} else if obj.data[0] == ';' {
 		refIndex, _ := parseInt(obj.data[1:])
 		cache := *(obj.symbolCache)
+		if refIndex < 0 || int(refIndex) >= len(cache) {
+			err = InvalidBackReference
+			return
+		}
 		value = cache[refIndex]
 	} else {
 		value, _, cache = parseStringWithEncoding(obj.data[2:])
@@ -152,6 +185,21 @@ func (obj *MarshalledObject) GetAsString() (value string, err error) {
 	return
 }
 
+// GetAsSymbol returns the value of a Ruby Symbol. Unlike GetAsString, it
+// rejects plain Strings so callers can tell the two apart.
+func (obj *MarshalledObject) GetAsSymbol() (value string, err error) {
+	if ref := obj.resolveObjectLink(); ref != nil {
+		return ref.GetAsSymbol()
+	}
+
+	err = assertType(obj, TypeSymbol)
+	if err != nil {
+		return
+	}
+
+	return obj.GetAsString()
+}
+
 func (obj *MarshalledObject) GetAsArray() (value []*MarshalledObject, err error) {
 	if ref := obj.resolveObjectLink(); ref != nil {
 		return ref.GetAsArray()
@@ -243,6 +291,20 @@ func (obj *MarshalledObject) GetAsMap() (value map[string]*MarshalledObject, err
 		offset += valueSize
 	}
 
+	if obj.data[0] == '}' {
+		// Hash with a default value (Hash.new(default)); the default
+		// trails the entries and isn't exposed through this API, but
+		// its bytes must still be skipped so offsets after this object
+		// stay correct.
+		offset += newMarshalledObject(
+			obj.MajorVersion,
+			obj.MinorVersion,
+			obj.data[offset:],
+			obj.symbolCache,
+			obj.objectCache,
+		).getSize()
+	}
+
 	obj.size = offset
 
 	return
@@ -265,6 +327,11 @@ func (obj *MarshalledObject) getSize() int {
 		return headerSize + dataSize
 	}
 
+	if len(obj.data) > 0 && obj.data[0] == 'e' {
+		inner := obj.unwrapExtended()
+		return (len(obj.data) - len(inner.data)) + inner.getSize()
+	}
+
 	switch obj.GetType() {
 	case TypeNil, TypeBool:
 		headerSize = 0
@@ -272,24 +339,28 @@ func (obj *MarshalledObject) getSize() int {
 	case TypeInteger:
 		headerSize = 1
 		_, dataSize = parseInt(obj.data[headerSize:])
-	case TypeString, TypeFloat:
+	case TypeFloat:
+		headerSize = 1
+
+		var symbol string
+		symbol, dataSize = parseString(obj.data[headerSize:])
+		obj.cacheSymbols(symbol)
+	case TypeSymbol:
 		headerSize = 1
 
 		if obj.data[0] == ';' {
 			_, dataSize = parseInt(obj.data[headerSize:])
 		} else {
-			var cache []string
-
-			if obj.data[0] == 'I' {
-				headerSize += 1
-				_, dataSize, cache = parseStringWithEncoding(obj.data[headerSize:])
-				obj.cacheSymbols(cache...)
-			} else {
-				var symbol string
-				symbol, dataSize = parseString(obj.data[headerSize:])
-				obj.cacheSymbols(symbol)
-			}
+			var symbol string
+			symbol, dataSize = parseString(obj.data[headerSize:])
+			obj.cacheSymbols(symbol)
 		}
+	case TypeString:
+		headerSize = 2
+
+		var cache []string
+		_, dataSize, cache = parseStringWithEncoding(obj.data[headerSize:])
+		obj.cacheSymbols(cache...)
 	case TypeArray:
 		if obj.size == 0 {
 			obj.GetAsArray()
@@ -302,6 +373,32 @@ func (obj *MarshalledObject) getSize() int {
 		}
 
 		return obj.size
+	case TypeBignum:
+		headerSize = 2
+
+		words, intSize := parseInt(obj.data[headerSize:])
+		dataSize = intSize + int(words)*2
+	case TypeRegexp:
+		headerSize = 1
+
+		var strSize int
+		_, strSize = parseString(obj.data[headerSize:])
+		dataSize = strSize + 1
+	case TypeObject:
+		if obj.size == 0 {
+			obj.GetAsObject()
+		}
+
+		return obj.size
+	case TypeUserDefined:
+		if obj.size == 0 {
+			obj.GetAsUserDefined()
+		}
+
+		return obj.size
+	case TypeClass, TypeModule:
+		headerSize = 1
+		_, dataSize = parseString(obj.data[headerSize:])
 	}
 
 	return headerSize + dataSize
@@ -334,7 +431,9 @@ func (obj *MarshalledObject) cacheObject(object *MarshalledObject) {
 	if len(object.data) > 0 && (object.data[0] == '@' || object.data[0] == ':' || object.data[0] == ';') {
 		return
 	}
-	if t := obj.GetType(); !(t == TypeString || t == TypeArray || t == TypeMap) {
+	switch object.GetType() {
+	case TypeString, TypeArray, TypeMap, TypeBignum, TypeObject, TypeRegexp, TypeUserDefined, TypeClass, TypeModule, TypeFloat:
+	default:
 		return
 	}
 
@@ -365,7 +464,7 @@ func (obj *MarshalledObject) ToString() (str string) {
 	case TypeInteger:
 		v, _ := obj.GetAsInteger()
 		str = strconv.FormatInt(v, 10)
-	case TypeString:
+	case TypeString, TypeSymbol:
 		str, _ = obj.GetAsString()
 	case TypeFloat:
 		v, _ := obj.GetAsFloat()
@@ -380,7 +479,7 @@ func (obj *MarshalledObject) resolveObjectLink() *MarshalledObject {
 		idx, _ := parseInt(obj.data[1:])
 		cache := *(obj.objectCache)
 
-		if int(idx) < len(cache) {
+		if idx >= 0 && int(idx) < len(cache) {
 			return cache[idx]
 		}
 	}
@@ -0,0 +1,240 @@
+package marshal
+
+import (
+	"math/big"
+	"testing"
+)
+
+// These fixtures are the documented byte layout of Ruby Marshal 4.8 for
+// each value (the same bytes `ruby -rbase64 -e 'print Marshal.dump(...)'`
+// would print, decoded from base64) — spelled out as raw byte literals
+// here since this sandbox has no ruby binary to shell out to.
+var (
+	fixtureNil     = []byte{4, 8, '0'}
+	fixtureTrue    = []byte{4, 8, 'T'}
+	fixtureFalse   = []byte{4, 8, 'F'}
+	fixtureIntZero = []byte{4, 8, 'i', 0}
+	fixtureIntOne  = []byte{4, 8, 'i', 6}
+	fixtureIntNeg1 = []byte{4, 8, 'i', 0xfa}
+	fixtureInt100  = []byte{4, 8, 'i', 'i'}
+	// Marshal.dump(1000): 1000 == 0x03e8, little-endian [0xe8, 0x03].
+	fixtureInt1000 = []byte{4, 8, 'i', 2, 0xe8, 0x03}
+	// Marshal.dump(1.5)
+	fixtureFloat = []byte{4, 8, 'f', 8, '1', '.', '5'}
+	// Marshal.dump(:foo)
+	fixtureSymbol = []byte{4, 8, ':', 8, 'f', 'o', 'o'}
+	// Marshal.dump("foo")
+	fixtureString = []byte{4, 8, 'I', '"', 8, 'f', 'o', 'o', 6, ':', 6, 'E', 'T'}
+	// Marshal.dump([1, 2, 3])
+	fixtureArray = []byte{4, 8, '[', 8, 'i', 6, 'i', 7, 'i', 8}
+	// Marshal.dump({a: 1})
+	fixtureHash = []byte{4, 8, '{', 6, ':', 6, 'a', 'i', 6}
+	// Marshal.dump(:c) referenced twice: [:c, :c]
+	fixtureSymbolLink = []byte{4, 8, '[', 7, ':', 6, 'c', ';', 0}
+)
+
+func TestGetType(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want marshalledObjectType
+	}{
+		{"nil", fixtureNil, TypeNil},
+		{"true", fixtureTrue, TypeBool},
+		{"false", fixtureFalse, TypeBool},
+		{"integer", fixtureIntOne, TypeInteger},
+		{"float", fixtureFloat, TypeFloat},
+		{"symbol", fixtureSymbol, TypeSymbol},
+		{"string", fixtureString, TypeString},
+		{"array", fixtureArray, TypeArray},
+		{"hash", fixtureHash, TypeMap},
+		{"class", []byte{4, 8, 'c', 6, 'I', 'O'}, TypeClass},
+		{"module", []byte{4, 8, 'm', 6, 'I', 'O'}, TypeModule},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			obj := CreateMarshalledObject(c.data)
+			if got := obj.GetType(); got != c.want {
+				t.Errorf("GetType() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGetAsScalars(t *testing.T) {
+	if v, err := CreateMarshalledObject(fixtureTrue).GetAsBool(); err != nil || v != true {
+		t.Errorf("GetAsBool() = %v, %v; want true, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureFalse).GetAsBool(); err != nil || v != false {
+		t.Errorf("GetAsBool() = %v, %v; want false, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureIntOne).GetAsInteger(); err != nil || v != 1 {
+		t.Errorf("GetAsInteger() = %v, %v; want 1, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureIntNeg1).GetAsInteger(); err != nil || v != -1 {
+		t.Errorf("GetAsInteger() = %v, %v; want -1, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureInt100).GetAsInteger(); err != nil || v != 100 {
+		t.Errorf("GetAsInteger() = %v, %v; want 100, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureInt1000).GetAsInteger(); err != nil || v != 1000 {
+		t.Errorf("GetAsInteger() = %v, %v; want 1000, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureFloat).GetAsFloat(); err != nil || v != 1.5 {
+		t.Errorf("GetAsFloat() = %v, %v; want 1.5, nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureSymbol).GetAsSymbol(); err != nil || v != "foo" {
+		t.Errorf("GetAsSymbol() = %q, %v; want \"foo\", nil", v, err)
+	}
+	if v, err := CreateMarshalledObject(fixtureString).GetAsString(); err != nil || v != "foo" {
+		t.Errorf("GetAsString() = %q, %v; want \"foo\", nil", v, err)
+	}
+}
+
+func TestGetAsArray(t *testing.T) {
+	elems, err := CreateMarshalledObject(fixtureArray).GetAsArray()
+	if err != nil {
+		t.Fatalf("GetAsArray() error = %v", err)
+	}
+	if len(elems) != 3 {
+		t.Fatalf("GetAsArray() len = %d, want 3", len(elems))
+	}
+	for i, want := range []int64{1, 2, 3} {
+		got, err := elems[i].GetAsInteger()
+		if err != nil || got != want {
+			t.Errorf("elems[%d] = %v, %v; want %d, nil", i, got, err, want)
+		}
+	}
+}
+
+func TestGetAsMap(t *testing.T) {
+	entries, err := CreateMarshalledObject(fixtureHash).GetAsMap()
+	if err != nil {
+		t.Fatalf("GetAsMap() error = %v", err)
+	}
+	v, ok := entries["a"]
+	if !ok {
+		t.Fatalf("GetAsMap() missing key %q", "a")
+	}
+	if n, err := v.GetAsInteger(); err != nil || n != 1 {
+		t.Errorf("entries[\"a\"] = %v, %v; want 1, nil", n, err)
+	}
+}
+
+func TestSymbolBackReference(t *testing.T) {
+	elems, err := CreateMarshalledObject(fixtureSymbolLink).GetAsArray()
+	if err != nil {
+		t.Fatalf("GetAsArray() error = %v", err)
+	}
+	if len(elems) != 2 {
+		t.Fatalf("len = %d, want 2", len(elems))
+	}
+	for i, elem := range elems {
+		if s, err := elem.GetAsSymbol(); err != nil || s != "c" {
+			t.Errorf("elems[%d] = %q, %v; want \"c\", nil", i, s, err)
+		}
+	}
+}
+
+func TestGetAsStringInvalidBackReference(t *testing.T) {
+	// ';' back-reference with no symbols cached yet.
+	data := []byte{4, 8, ';', 6}
+	_, err := CreateMarshalledObject(data).GetAsString()
+	if err != InvalidBackReference {
+		t.Errorf("GetAsString() err = %v, want InvalidBackReference", err)
+	}
+}
+
+func TestGetAsClassName(t *testing.T) {
+	data := []byte{4, 8, 'c', 8, 'I', 'n', 't'}
+	name, err := CreateMarshalledObject(data).GetAsClassName()
+	if err != nil {
+		t.Fatalf("GetAsClassName() error = %v", err)
+	}
+	if name != "Int" {
+		t.Errorf("GetAsClassName() = %q, want %q", name, "Int")
+	}
+}
+
+func TestClassRefDoesNotCorruptSiblingOffsets(t *testing.T) {
+	// [c"Foo, 1] — a class reference followed by a sibling integer.
+	// Before TypeClass/TypeModule were handled, getSize() fell through
+	// to TypeUnknown and returned 0, leaving the sibling parsed from the
+	// wrong offset.
+	data := []byte{4, 8, '[', 7, 'c', 8, 'F', 'o', 'o', 'i', 6}
+	elems, err := CreateMarshalledObject(data).GetAsArray()
+	if err != nil {
+		t.Fatalf("GetAsArray() error = %v", err)
+	}
+	if len(elems) != 2 {
+		t.Fatalf("len = %d, want 2", len(elems))
+	}
+	if n, err := elems[1].GetAsInteger(); err != nil || n != 1 {
+		t.Errorf("elems[1] = %v, %v; want 1, nil", n, err)
+	}
+}
+
+func TestFloatRefDoesNotCorruptSiblingOffsets(t *testing.T) {
+	// x = 1.5; [x, x, 1] — a repeated Float followed by a sibling
+	// integer. Before Float was added to cacheObject's allow-list, the
+	// object-link table never reserved a slot for x, so the second
+	// element's '@' link (index 1) fell outside the table and did not
+	// resolve, and any later '@' index in the document was off by one
+	// relative to real Ruby Marshal output.
+	data := []byte{4, 8, '[', 8, 'f', 8, '1', '.', '5', '@', 6, 'i', 6}
+	elems, err := CreateMarshalledObject(data).GetAsArray()
+	if err != nil {
+		t.Fatalf("GetAsArray() error = %v", err)
+	}
+	if len(elems) != 3 {
+		t.Fatalf("len = %d, want 3", len(elems))
+	}
+	if f, err := elems[1].GetAsFloat(); err != nil || f != 1.5 {
+		t.Errorf("elems[1] = %v, %v; want 1.5, nil", f, err)
+	}
+	if n, err := elems[2].GetAsInteger(); err != nil || n != 1 {
+		t.Errorf("elems[2] = %v, %v; want 1, nil", n, err)
+	}
+}
+
+func TestGetAsBignum(t *testing.T) {
+	// A Bignum ('l') encoding of 32768: one 2-byte word, little-endian
+	// [0x00, 0x80].
+	data := []byte{4, 8, 'l', '+', 6, 0x00, 0x80}
+	got, err := CreateMarshalledObject(data).GetAsBignum()
+	if err != nil {
+		t.Fatalf("GetAsBignum() error = %v", err)
+	}
+	want := big.NewInt(32768)
+	if got.Cmp(want) != 0 {
+		t.Errorf("GetAsBignum() = %v, want %v", got, want)
+	}
+}
+
+func TestGetAsBignumNegative(t *testing.T) {
+	data := []byte{4, 8, 'l', '-', 6, 0x00, 0x80}
+	got, err := CreateMarshalledObject(data).GetAsBignum()
+	if err != nil {
+		t.Fatalf("GetAsBignum() error = %v", err)
+	}
+	want := big.NewInt(-32768)
+	if got.Cmp(want) != 0 {
+		t.Errorf("GetAsBignum() = %v, want %v", got, want)
+	}
+}
+
+func TestGetAsBignumTruncated(t *testing.T) {
+	cases := [][]byte{
+		{4, 8, 'l'},
+		{4, 8, 'l', '+'},
+		{4, 8, 'l', '+', 5, 0, 0},
+		{4, 8, 'l', '+', 6},
+		{4, 8, 'l', '+', 6, 0x00},
+	}
+	for _, data := range cases {
+		if _, err := CreateMarshalledObject(data).GetAsBignum(); err != IncompleteData {
+			t.Errorf("GetAsBignum(%v) error = %v, want IncompleteData", data, err)
+		}
+	}
+}
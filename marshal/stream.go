@@ -0,0 +1,650 @@
+package marshal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// TokenType identifies the kind of event a TokenDecoder produced, in the
+// style of encoding/xml.Token.
+type TokenType int
+
+const (
+	TokenEOF TokenType = iota
+	TokenNil
+	TokenBool
+	TokenInteger
+	TokenFloat
+	TokenString
+	TokenSymbol
+	TokenBignum
+	TokenRegexp
+	TokenBeginArray
+	TokenEndArray
+	TokenBeginMap
+	TokenEndMap
+	TokenBeginObject
+	TokenEndObject
+)
+
+// Token is a single parsing event from a TokenDecoder.
+type Token struct {
+	Type TokenType
+
+	Bool    bool
+	Integer int64
+	Float   float64
+	String  string
+	Bignum  *big.Int
+
+	// RegexpFlags holds the raw Onigmo option bits for a TokenRegexp.
+	RegexpFlags int
+
+	// Len is the element count of a BeginArray, the key/value pair count
+	// of a BeginMap, or the instance-variable count of a BeginObject.
+	Len int
+}
+
+var ErrMaxDepth = errors.New("gorails/marshal: exceeded Decoder.MaxDepth")
+var ErrMaxCacheSize = errors.New("gorails/marshal: exceeded Decoder.MaxCacheSize")
+var ErrBackReferenceNotRetained = errors.New("gorails/marshal: the referenced object fell outside Decoder.MaxCacheSize and was not retained")
+var ErrUnsupportedStreamingType = errors.New("gorails/marshal: type not supported by TokenDecoder")
+
+type frameKind int
+
+const (
+	frameArray frameKind = iota
+	frameMap
+	frameObject
+)
+
+type tokenFrame struct {
+	kind      frameKind
+	remaining int
+
+	// objIndex is the back-reference index this frame's Begin token was
+	// registered under. start is the position in the Decoder's shared
+	// log at which this frame's replay begins; record is false once
+	// retaining this frame would exceed MaxCacheSize, in which case its
+	// range is never committed to cache.
+	objIndex int
+	record   bool
+	start    int
+}
+
+// tokenRange is a half-open [start, end) slice of TokenDecoder.log: the
+// replay of one retained object. A zero value (start == end) means the
+// object wasn't retained.
+type tokenRange struct {
+	start, end int
+}
+
+// TokenDecoder parses a Ruby Marshal 4.8 stream one token at a time from a
+// buffered io.Reader, without reading the whole payload into memory or
+// building a MarshalledObject tree up front. Symbol and object
+// back-references are resolved against replay caches kept on the Decoder,
+// which grow with the stream rather than with payload size; set
+// MaxCacheSize to bound them, and MaxDepth to bound array/map/object
+// nesting, when decoding untrusted input. Every retained token is
+// appended once to a single shared log, and each retained object/frame
+// just remembers its (start, end) range into it, so total retained
+// memory is O(tokens retained) — not multiplied by nesting depth the way
+// copying a token into every open ancestor frame's own buffer would be.
+// With the default MaxCacheSize of 0 ("unlimited"), a stream containing
+// back-references ends up retaining the whole document exactly once,
+// comparable to (not worse than) building a full MarshalledObject tree;
+// set MaxCacheSize to bound memory below that for untrusted input. Once
+// the replay log would grow past MaxCacheSize tokens, the Decoder stops
+// retaining further objects rather than failing outright: back-references
+// into the retained portion keep working, and '@' references past it
+// return ErrBackReferenceNotRetained. The number of symbols plus
+// registered objects is bounded by MaxCacheSize too, and reaching that
+// bound does fail, with ErrMaxCacheSize.
+//
+// Bignum, Regexp, and Object/Struct ('l', '/', 'o'/'S') are supported;
+// user-defined dumps ('u'/'U') and extended objects ('e') are not, and
+// return ErrUnsupportedStreamingType — they're rare in practice and each
+// would need their own recursive payload handling to stream correctly.
+type TokenDecoder struct {
+	// MaxDepth bounds array/map/object nesting depth. Zero means
+	// unlimited.
+	MaxDepth int
+	// MaxCacheSize bounds the number of tokens in the object
+	// back-reference replay log, and separately the number of symbols
+	// plus registered objects. Zero means unlimited.
+	MaxCacheSize int
+
+	r       *bufio.Reader
+	started bool
+
+	symbols []string
+	objects int
+
+	stack   []tokenFrame
+	pending []Token
+
+	// log is the single append-only buffer every retained frame and
+	// atomic object's replay range points into.
+	log       []Token
+	cacheFull bool
+
+	// cache[i] is the retained range of the i'th registered object.
+	cache []tokenRange
+}
+
+// NewTokenDecoder returns a TokenDecoder that reads from r.
+func NewTokenDecoder(r io.Reader) *TokenDecoder {
+	return &TokenDecoder{r: bufio.NewReader(r)}
+}
+
+// Token returns the next parsing event, or an error (io.EOF once the
+// stream is exhausted at a top-level boundary).
+func (d *TokenDecoder) Token() (Token, error) {
+	if len(d.pending) > 0 {
+		tok := d.pending[0]
+		d.pending = d.pending[1:]
+		d.recordChild(tok)
+		return tok, nil
+	}
+
+	if !d.started {
+		if _, err := d.readByte(); err != nil {
+			return Token{}, err
+		}
+		if _, err := d.readByte(); err != nil {
+			return Token{}, err
+		}
+		d.started = true
+	}
+
+	if n := len(d.stack); n > 0 {
+		top := &d.stack[n-1]
+		if top.remaining == 0 {
+			endTok := endToken(top.kind)
+			d.recordChild(endTok)
+			if top.record {
+				d.cache[top.objIndex] = tokenRange{top.start, len(d.log)}
+			}
+			d.stack = d.stack[:n-1]
+			return endTok, nil
+		}
+		top.remaining--
+	}
+
+	tag, err := d.readByte()
+	if err != nil {
+		return Token{}, err
+	}
+
+	switch tag {
+	case '0':
+		tok := Token{Type: TokenNil}
+		d.recordChild(tok)
+		return tok, nil
+	case 'T':
+		tok := Token{Type: TokenBool, Bool: true}
+		d.recordChild(tok)
+		return tok, nil
+	case 'F':
+		tok := Token{Type: TokenBool, Bool: false}
+		d.recordChild(tok)
+		return tok, nil
+	case 'i':
+		n, err := d.readVarint()
+		if err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenInteger, Integer: n}
+		d.recordChild(tok)
+		return tok, nil
+	case 'f':
+		s, err := d.readRawString()
+		if err != nil {
+			return Token{}, err
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Token{}, err
+		}
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenFloat, Float: f}
+		d.finalizeAtomic(idx, tok)
+		return tok, nil
+	case ':':
+		s, err := d.readRawString()
+		if err != nil {
+			return Token{}, err
+		}
+		if err := d.cacheSymbol(s); err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenSymbol, String: s}
+		d.recordChild(tok)
+		return tok, nil
+	case ';':
+		s, err := d.resolveSymbolRef()
+		if err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenSymbol, String: s}
+		d.recordChild(tok)
+		return tok, nil
+	case 'I':
+		quote, err := d.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		if quote != '"' {
+			return Token{}, ErrUnsupportedStreamingType
+		}
+		s, err := d.readRawString()
+		if err != nil {
+			return Token{}, err
+		}
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		if err := d.skipIvars(); err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenString, String: s}
+		d.finalizeAtomic(idx, tok)
+		return tok, nil
+	case 'l':
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		tok, err := d.readBignum()
+		if err != nil {
+			return Token{}, err
+		}
+		d.finalizeAtomic(idx, tok)
+		return tok, nil
+	case '/':
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		pattern, err := d.readRawString()
+		if err != nil {
+			return Token{}, err
+		}
+		flags, err := d.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenRegexp, String: pattern, RegexpFlags: int(flags)}
+		d.finalizeAtomic(idx, tok)
+		return tok, nil
+	case '@':
+		idx, err := d.readVarint()
+		if err != nil {
+			return Token{}, err
+		}
+		if idx < 0 || int(idx) >= d.objects {
+			return Token{}, InvalidBackReference
+		}
+		cached := d.cache[idx]
+		if cached.end <= cached.start {
+			return Token{}, ErrBackReferenceNotRetained
+		}
+		d.pending = append(d.pending, d.log[cached.start:cached.end]...)
+		return d.Token()
+	case '[':
+		n, err := d.readVarint()
+		if err != nil {
+			return Token{}, err
+		}
+		if n < 0 {
+			return Token{}, ErrUnsupportedStreamingType
+		}
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		tok := Token{Type: TokenBeginArray, Len: int(n)}
+		if err := d.pushFrame(frameArray, int(n), idx, tok); err != nil {
+			return Token{}, err
+		}
+		return tok, nil
+	case '{', '}':
+		n, err := d.readVarint()
+		if err != nil {
+			return Token{}, err
+		}
+		if n < 0 {
+			return Token{}, ErrUnsupportedStreamingType
+		}
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		remaining := int(n) * 2
+		if tag == '}' {
+			// Hash with a default value: one extra trailing child
+			// after the len pairs.
+			remaining++
+		}
+		tok := Token{Type: TokenBeginMap, Len: int(n)}
+		if err := d.pushFrame(frameMap, remaining, idx, tok); err != nil {
+			return Token{}, err
+		}
+		return tok, nil
+	case 'o', 'S':
+		idx, err := d.registerObject()
+		if err != nil {
+			return Token{}, err
+		}
+		className, err := d.readSymbolRef()
+		if err != nil {
+			return Token{}, err
+		}
+		n, err := d.readVarint()
+		if err != nil {
+			return Token{}, err
+		}
+		if n < 0 {
+			return Token{}, ErrUnsupportedStreamingType
+		}
+		tok := Token{Type: TokenBeginObject, String: className, Len: int(n)}
+		if err := d.pushFrame(frameObject, int(n)*2, idx, tok); err != nil {
+			return Token{}, err
+		}
+		return tok, nil
+	}
+
+	return Token{}, ErrUnsupportedStreamingType
+}
+
+func endToken(kind frameKind) Token {
+	switch kind {
+	case frameMap:
+		return Token{Type: TokenEndMap}
+	case frameObject:
+		return Token{Type: TokenEndObject}
+	default:
+		return Token{Type: TokenEndArray}
+	}
+}
+
+func (d *TokenDecoder) readByte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+func (d *TokenDecoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readVarint mirrors parseInt's variable-length integer format, reading
+// incrementally from the buffered reader instead of a []byte.
+func (d *TokenDecoder) readVarint() (int64, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b > 0x05 && b < 0xfb {
+		value := int64(b)
+		if value > 0x7f {
+			return -(0xff ^ value + 1) + 5, nil
+		}
+		return value - 5, nil
+	}
+
+	if b <= 0x05 {
+		count := int(b)
+		buf := make([]byte, count)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			return 0, err
+		}
+
+		value := int64(0)
+		for i := count - 1; i >= 0; i-- {
+			value = value<<8 + int64(buf[i])
+		}
+		return value, nil
+	}
+
+	count := int(0xff - b + 1)
+	buf := make([]byte, count)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return 0, err
+	}
+
+	value := int64(0)
+	for i := count - 1; i >= 0; i-- {
+		value = value<<8 + (0xff - int64(buf[i]))
+	}
+	return -(value + 1), nil
+}
+
+func (d *TokenDecoder) readRawString() (string, error) {
+	n, err := d.readVarint()
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", ErrUnsupportedStreamingType
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readBignum parses the sign byte, word-count header and little-endian
+// word bytes of a Bignum ('l'), mirroring MarshalledObject.GetAsBignum.
+func (d *TokenDecoder) readBignum() (Token, error) {
+	sign, err := d.readByte()
+	if err != nil {
+		return Token{}, err
+	}
+
+	words, err := d.readVarint()
+	if err != nil {
+		return Token{}, err
+	}
+	if words < 0 {
+		return Token{}, ErrUnsupportedStreamingType
+	}
+
+	n := int(words) * 2
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return Token{}, err
+	}
+
+	bigEndian := make([]byte, n)
+	for i, b := range buf {
+		bigEndian[n-1-i] = b
+	}
+
+	value := new(big.Int).SetBytes(bigEndian)
+	if sign == '-' {
+		value.Neg(value)
+	}
+
+	return Token{Type: TokenBignum, Bignum: value}, nil
+}
+
+// readSymbolRef reads a Symbol definition (':') or back-reference (';')
+// — the form Ruby always uses to encode a class/module name — without
+// surfacing it as its own Token.
+func (d *TokenDecoder) readSymbolRef() (string, error) {
+	tag, err := d.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch tag {
+	case ':':
+		s, err := d.readRawString()
+		if err != nil {
+			return "", err
+		}
+		if err := d.cacheSymbol(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	case ';':
+		return d.resolveSymbolRef()
+	default:
+		return "", ErrUnsupportedStreamingType
+	}
+}
+
+func (d *TokenDecoder) resolveSymbolRef() (string, error) {
+	idx, err := d.readVarint()
+	if err != nil {
+		return "", err
+	}
+	if idx < 0 || int(idx) >= len(d.symbols) {
+		return "", InvalidBackReference
+	}
+	return d.symbols[idx], nil
+}
+
+// skipIvars consumes the `ivar_count (symbol value)*` trailer of an `I"`
+// string (the encoding envelope, e.g. `:E T` or `:encoding "UTF-8"`)
+// without surfacing it as tokens, mirroring parseStringWithEncoding's
+// handling of the same bytes in the buffered parser.
+func (d *TokenDecoder) skipIvars() error {
+	n, err := d.readVarint()
+	if err != nil {
+		return err
+	}
+
+	for i := int64(0); i < n; i++ {
+		tag, err := d.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch tag {
+		case ':':
+			s, err := d.readRawString()
+			if err != nil {
+				return err
+			}
+			if err := d.cacheSymbol(s); err != nil {
+				return err
+			}
+		case ';':
+			if _, err := d.readVarint(); err != nil {
+				return err
+			}
+		default:
+			return ErrUnsupportedStreamingType
+		}
+
+		peek, err := d.peekByte()
+		if err != nil {
+			return err
+		}
+
+		if peek == '"' {
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+			if _, err := d.readRawString(); err != nil {
+				return err
+			}
+		} else if _, err := d.readByte(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *TokenDecoder) cacheSymbol(s string) error {
+	if d.MaxCacheSize > 0 && len(d.symbols)+d.objects >= d.MaxCacheSize {
+		return ErrMaxCacheSize
+	}
+	d.symbols = append(d.symbols, s)
+	return nil
+}
+
+// registerObject assigns the next back-reference index and reserves its
+// (initially empty, i.e. not-yet-retained) slot in the cache.
+func (d *TokenDecoder) registerObject() (int, error) {
+	if d.MaxCacheSize > 0 && len(d.symbols)+d.objects >= d.MaxCacheSize {
+		return 0, ErrMaxCacheSize
+	}
+	idx := d.objects
+	d.objects++
+	d.cache = append(d.cache, tokenRange{})
+	return idx, nil
+}
+
+// pushFrame opens a new Array/Map/Object frame, appending its Begin token
+// to the shared log (unconditionally — the frame always needs its own
+// start position, regardless of whether any ancestor is also recording,
+// since they all share the same log).
+func (d *TokenDecoder) pushFrame(kind frameKind, remaining int, objIndex int, beginTok Token) error {
+	if d.MaxDepth > 0 && len(d.stack)+1 > d.MaxDepth {
+		return ErrMaxDepth
+	}
+
+	frame := tokenFrame{kind: kind, remaining: remaining, objIndex: objIndex, start: len(d.log)}
+	frame.record = d.appendLog(beginTok)
+	d.stack = append(d.stack, frame)
+	return nil
+}
+
+// recordChild appends tok to the shared log if some currently open frame
+// will need to replay it as one of its children. A token with nothing
+// open above it can never be reached by a later '@', so there's nothing
+// to retain it for.
+func (d *TokenDecoder) recordChild(tok Token) {
+	for i := range d.stack {
+		if d.stack[i].record {
+			d.appendLog(tok)
+			return
+		}
+	}
+}
+
+// finalizeAtomic appends tok (a String, Float, Bignum or Regexp — cacheable but
+// not itself a Begin/End pair) to the shared log and, if retained,
+// records its one-token replay range.
+func (d *TokenDecoder) finalizeAtomic(idx int, tok Token) {
+	start := len(d.log)
+	if d.appendLog(tok) {
+		d.cache[idx] = tokenRange{start, start + 1}
+	}
+}
+
+// appendLog appends tok to the shared replay log, subject to
+// MaxCacheSize. Once the budget is exhausted, retention is abandoned for
+// the log as a whole (including every currently open frame, whose
+// ranges would otherwise be left incomplete) rather than partially
+// evicting old entries.
+func (d *TokenDecoder) appendLog(tok Token) bool {
+	if d.cacheFull {
+		return false
+	}
+	if d.MaxCacheSize > 0 && len(d.log)+1 > d.MaxCacheSize {
+		d.cacheFull = true
+		for i := range d.stack {
+			d.stack[i].record = false
+		}
+		return false
+	}
+	d.log = append(d.log, tok)
+	return true
+}
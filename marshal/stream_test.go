@@ -0,0 +1,232 @@
+package marshal
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"testing"
+)
+
+func TestTokenDecoderScalars(t *testing.T) {
+	dec := NewTokenDecoder(bytes.NewReader(fixtureInt1000))
+	tok, err := dec.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.Type != TokenInteger || tok.Integer != 1000 {
+		t.Errorf("Token() = %+v, want Integer 1000", tok)
+	}
+}
+
+func TestTokenDecoderArray(t *testing.T) {
+	dec := NewTokenDecoder(bytes.NewReader(fixtureArray))
+
+	tok, err := dec.Token()
+	if err != nil || tok.Type != TokenBeginArray || tok.Len != 3 {
+		t.Fatalf("Token() = %+v, %v; want BeginArray len 3", tok, err)
+	}
+
+	var got []int64
+	for i := 0; i < 3; i++ {
+		tok, err := dec.Token()
+		if err != nil || tok.Type != TokenInteger {
+			t.Fatalf("Token() = %+v, %v; want Integer", tok, err)
+		}
+		got = append(got, tok.Integer)
+	}
+
+	tok, err = dec.Token()
+	if err != nil || tok.Type != TokenEndArray {
+		t.Fatalf("Token() = %+v, %v; want EndArray", tok, err)
+	}
+
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("elements = %v, want [1 2 3]", got)
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		t.Errorf("Token() at end = %v, want io.EOF", err)
+	}
+}
+
+func TestTokenDecoderString(t *testing.T) {
+	dec := NewTokenDecoder(bytes.NewReader(fixtureString))
+	tok, err := dec.Token()
+	if err != nil || tok.Type != TokenString || tok.String != "foo" {
+		t.Fatalf("Token() = %+v, %v; want String \"foo\"", tok, err)
+	}
+}
+
+func TestTokenDecoderMaxDepth(t *testing.T) {
+	// [[1]] — one level of nesting beyond MaxDepth.
+	data := []byte{4, 8, '[', 6, '[', 6, 'i', 6}
+
+	dec := NewTokenDecoder(bytes.NewReader(data))
+	dec.MaxDepth = 1
+
+	if _, err := dec.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if _, err := dec.Token(); err != ErrMaxDepth {
+		t.Errorf("Token() error = %v, want ErrMaxDepth", err)
+	}
+}
+
+func TestTokenDecoderInvalidBackReference(t *testing.T) {
+	// A symbol back-reference to an index with nothing cached yet.
+	data := []byte{4, 8, ';', 6}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != InvalidBackReference {
+		t.Errorf("Token() error = %v, want InvalidBackReference", err)
+	}
+}
+
+func TestTokenDecoderObjectBackReference(t *testing.T) {
+	// x = [1, 2, 3]; [x, x]
+	data := []byte{4, 8, '[', 7, '[', 8, 'i', 6, 'i', 7, 'i', 8, '@', 6}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+
+	want := []struct {
+		typ TokenType
+		n   int64
+	}{
+		{TokenBeginArray, 0},
+		{TokenBeginArray, 0},
+		{TokenInteger, 1},
+		{TokenInteger, 2},
+		{TokenInteger, 3},
+		{TokenEndArray, 0},
+		{TokenBeginArray, 0},
+		{TokenInteger, 1},
+		{TokenInteger, 2},
+		{TokenInteger, 3},
+		{TokenEndArray, 0},
+		{TokenEndArray, 0},
+	}
+
+	for i, w := range want {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("Token() #%d error = %v", i, err)
+		}
+		if tok.Type != w.typ || (w.typ == TokenInteger && tok.Integer != w.n) {
+			t.Fatalf("Token() #%d = %+v, want type %v n %d", i, tok, w.typ, w.n)
+		}
+	}
+}
+
+func TestTokenDecoderBackReferenceNotRetained(t *testing.T) {
+	// x = [1, 2, 3]; [x, x], with too little cache budget to retain x.
+	data := []byte{4, 8, '[', 7, '[', 8, 'i', 6, 'i', 7, 'i', 8, '@', 6}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+	dec.MaxCacheSize = 3
+
+	// Too little cache budget to retain the inner array's replay buffer;
+	// the eventual '@' back-reference to it must fail rather than panic
+	// or silently replay nothing.
+	for {
+		_, err := dec.Token()
+		if err == ErrBackReferenceNotRetained {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Token() error = %v, want eventual ErrBackReferenceNotRetained", err)
+		}
+	}
+}
+
+func TestTokenDecoderStringBackReference(t *testing.T) {
+	// s = "foo"; [s, s]
+	data := []byte{4, 8, '[', 7, 'I', '"', 8, 'f', 'o', 'o', 6, ':', 6, 'E', 'T', '@', 6}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+
+	if tok, err := dec.Token(); err != nil || tok.Type != TokenBeginArray {
+		t.Fatalf("Token() = %+v, %v; want BeginArray", tok, err)
+	}
+	for i := 0; i < 2; i++ {
+		tok, err := dec.Token()
+		if err != nil || tok.Type != TokenString || tok.String != "foo" {
+			t.Fatalf("Token() #%d = %+v, %v; want String \"foo\"", i, tok, err)
+		}
+	}
+	if tok, err := dec.Token(); err != nil || tok.Type != TokenEndArray {
+		t.Fatalf("Token() = %+v, %v; want EndArray", tok, err)
+	}
+}
+
+func TestTokenDecoderBignum(t *testing.T) {
+	// A Bignum ('l') encoding of 32768: one 2-byte word, little-endian
+	// [0x00, 0x80].
+	data := []byte{4, 8, 'l', '+', 6, 0x00, 0x80}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.Type != TokenBignum || tok.Bignum.Cmp(big.NewInt(32768)) != 0 {
+		t.Errorf("Token() = %+v, want Bignum 32768", tok)
+	}
+}
+
+func TestTokenDecoderRegexp(t *testing.T) {
+	// A bare Regexp ('/') with source "abc" and no option flags.
+	data := []byte{4, 8, '/', 8, 'a', 'b', 'c', 0}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.Type != TokenRegexp || tok.String != "abc" || tok.RegexpFlags != 0 {
+		t.Errorf("Token() = %+v, want Regexp \"abc\" flags 0", tok)
+	}
+}
+
+func TestTokenDecoderSharedLogNotDuplicatedPerDepth(t *testing.T) {
+	// [[[1, 2, 3]]] — three nested arrays, each retained the whole time.
+	data := []byte{
+		4, 8,
+		'[', 6,
+		'[', 6,
+		'[', 8, 'i', 6, 'i', 7, 'i', 8,
+	}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+
+	wantTokens := 9 // 3 BeginArray + 3 Integer + 3 EndArray
+	for i := 0; i < wantTokens; i++ {
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("Token() #%d error = %v", i, err)
+		}
+	}
+
+	// A per-frame-copy design would retain 9+7+5 = 21 entries here (one
+	// copy per still-open ancestor); the shared log should hold each
+	// token exactly once regardless of how many ranges point into it.
+	if len(dec.log) != wantTokens {
+		t.Errorf("len(dec.log) = %d, want %d (retained once, not once per nesting depth)", len(dec.log), wantTokens)
+	}
+}
+
+func TestTokenDecoderObject(t *testing.T) {
+	// An object ('o') of class Foo with one ivar, @bar = 1.
+	data := []byte{
+		4, 8, 'o',
+		':', 8, 'F', 'o', 'o',
+		6,
+		':', 9, '@', 'b', 'a', 'r', 'i', 6,
+	}
+	dec := NewTokenDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil || tok.Type != TokenBeginObject || tok.String != "Foo" || tok.Len != 1 {
+		t.Fatalf("Token() = %+v, %v; want BeginObject \"Foo\" len 1", tok, err)
+	}
+	if tok, err := dec.Token(); err != nil || tok.Type != TokenSymbol || tok.String != "@bar" {
+		t.Fatalf("Token() = %+v, %v; want Symbol \"@bar\"", tok, err)
+	}
+	if tok, err := dec.Token(); err != nil || tok.Type != TokenInteger || tok.Integer != 1 {
+		t.Fatalf("Token() = %+v, %v; want Integer 1", tok, err)
+	}
+	if tok, err := dec.Token(); err != nil || tok.Type != TokenEndObject {
+		t.Fatalf("Token() = %+v, %v; want EndObject", tok, err)
+	}
+}
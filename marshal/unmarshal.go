@@ -0,0 +1,416 @@
+package marshal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+// UnmarshalTypeError describes a value that was not appropriate for a
+// given Go type, together with the path inside the marshalled object
+// where the mismatch was found (e.g. `["user"]["id"]`).
+type UnmarshalTypeError struct {
+	GoType reflect.Type
+	Path   string
+	Err    error
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("gorails/marshal: cannot unmarshal into %s at %s: %s", e.GoType, e.Path, e.Err)
+}
+
+func (e *UnmarshalTypeError) Unwrap() error {
+	return e.Err
+}
+
+// Unmarshal parses Ruby-Marshal-encoded data and stores the result in the
+// value pointed to by v, following the same conventions as
+// encoding/json.Unmarshal: v must be a non-nil pointer, and maps, slices,
+// struct fields, primitives, and interface{} are all populated via
+// reflection.
+func Unmarshal(data []byte, v interface{}) error {
+	if len(data) < 2 {
+		return IncompleteData
+	}
+	obj := CreateMarshalledObject(data)
+	return decodeInto(obj, reflect.ValueOf(v), "")
+}
+
+// Decoder reads and decodes a single Ruby-Marshal value from an input
+// stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the whole of the underlying reader and unmarshals it into v.
+func (dec *Decoder) Decode(v interface{}) error {
+	data, err := ioutil.ReadAll(dec.r)
+	if err != nil {
+		return err
+	}
+
+	return Unmarshal(data, v)
+}
+
+func decodeInto(obj *MarshalledObject, rv reflect.Value, path string) error {
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("gorails/marshal: Unmarshal requires a non-nil pointer, got %s", rv.Type())
+	}
+
+	return decodeValue(obj, rv.Elem(), path)
+}
+
+func decodeValue(obj *MarshalledObject, rv reflect.Value, path string) error {
+	// Unwrap one level of pointer, allocating as needed.
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(obj, rv.Elem(), path)
+	}
+
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		value, err := decodeAny(obj, path)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch obj.GetType() {
+	case TypeNil:
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	case TypeBool:
+		return decodeBool(obj, rv, path)
+	case TypeInteger:
+		return decodeInteger(obj, rv, path)
+	case TypeFloat:
+		return decodeFloat(obj, rv, path)
+	case TypeString, TypeSymbol:
+		return decodeString(obj, rv, path)
+	case TypeArray:
+		return decodeArray(obj, rv, path)
+	case TypeMap:
+		return decodeMap(obj, rv, path)
+	}
+
+	return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+}
+
+func decodeAny(obj *MarshalledObject, path string) (interface{}, error) {
+	switch obj.GetType() {
+	case TypeNil:
+		return nil, nil
+	case TypeBool:
+		return obj.GetAsBool()
+	case TypeInteger:
+		return obj.GetAsInteger()
+	case TypeFloat:
+		return obj.GetAsFloat()
+	case TypeString:
+		return obj.GetAsString()
+	case TypeSymbol:
+		name, err := obj.GetAsSymbol()
+		return Symbol(name), err
+	case TypeObject:
+		className, ivars, err := obj.GetAsObject()
+		if err != nil {
+			return nil, err
+		}
+		if factory, ok := lookupClass(className); ok {
+			return factory(className, ivars)
+		}
+		out := make(map[string]interface{}, len(ivars))
+		for k, v := range ivars {
+			dv, err := decodeAny(v, fmt.Sprintf("%s.%s", path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = dv
+		}
+		return out, nil
+	case TypeArray:
+		elems, err := obj.GetAsArray()
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			v, err := decodeAny(elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case TypeMap:
+		entries, err := obj.GetAsMap()
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(entries))
+		for k, v := range entries {
+			dv, err := decodeAny(v, fmt.Sprintf("%s[%q]", path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = dv
+		}
+		return out, nil
+	case TypeBignum:
+		return obj.GetAsBignum()
+	case TypeRegexp:
+		source, flags, err := obj.GetAsRegexp()
+		if err != nil {
+			return nil, err
+		}
+		return Regexp{Source: source, Flags: flags}, nil
+	case TypeUserDefined:
+		className, payload, err := obj.GetAsUserDefined()
+		if err != nil {
+			return nil, err
+		}
+		return UserDefined{ClassName: className, Payload: payload}, nil
+	case TypeClass:
+		name, err := obj.GetAsClassName()
+		return Class(name), err
+	case TypeModule:
+		name, err := obj.GetAsClassName()
+		return Module(name), err
+	}
+
+	return nil, &UnmarshalTypeError{GoType: reflect.TypeOf((*interface{})(nil)).Elem(), Path: path, Err: TypeMismatch}
+}
+
+func decodeBool(obj *MarshalledObject, rv reflect.Value, path string) error {
+	if rv.Kind() != reflect.Bool {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	value, err := obj.GetAsBool()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	rv.SetBool(value)
+	return nil
+}
+
+func decodeInteger(obj *MarshalledObject, rv reflect.Value, path string) error {
+	value, err := obj.GetAsInteger()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(uint64(value))
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(float64(value))
+	default:
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	return nil
+}
+
+func decodeFloat(obj *MarshalledObject, rv reflect.Value, path string) error {
+	if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	value, err := obj.GetAsFloat()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	rv.SetFloat(value)
+	return nil
+}
+
+func decodeString(obj *MarshalledObject, rv reflect.Value, path string) error {
+	if rv.Kind() != reflect.String {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	value, err := obj.GetAsString()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	rv.SetString(value)
+	return nil
+}
+
+func decodeArray(obj *MarshalledObject, rv reflect.Value, path string) error {
+	elems, err := obj.GetAsArray()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		out := reflect.MakeSlice(rv.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := decodeValue(elem, out.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(out)
+	case reflect.Array:
+		if len(elems) != rv.Len() {
+			return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: fmt.Errorf("array length mismatch: got %d, want %d", len(elems), rv.Len())}
+		}
+		for i, elem := range elems {
+			if err := decodeValue(elem, rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	return nil
+}
+
+func decodeMap(obj *MarshalledObject, rv reflect.Value, path string) error {
+	entries, err := obj.GetAsMap()
+	if err != nil {
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: err}
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return decodeStruct(entries, rv, path)
+	case reflect.Map:
+		out := reflect.MakeMapWithSize(rv.Type(), len(entries))
+		keyType := rv.Type().Key()
+		elemType := rv.Type().Elem()
+		for k, v := range entries {
+			if keyType.Kind() != reflect.String {
+				return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: fmt.Errorf("map key type %s is not supported", keyType)}
+			}
+
+			elemValue := reflect.New(elemType).Elem()
+			if err := decodeValue(v, elemValue, fmt.Sprintf("%s[%q]", path, k)); err != nil {
+				return err
+			}
+
+			keyValue := reflect.New(keyType).Elem()
+			keyValue.SetString(k)
+			out.SetMapIndex(keyValue, elemValue)
+		}
+		rv.Set(out)
+	default:
+		return &UnmarshalTypeError{GoType: rv.Type(), Path: path, Err: TypeMismatch}
+	}
+
+	return nil
+}
+
+// fieldInfo describes how a struct field was annotated via its `marshal`
+// tag.
+type fieldInfo struct {
+	index     int
+	name      string
+	omitempty bool
+	extras    bool
+}
+
+func structFields(t reflect.Type) (fields []fieldInfo, extrasField int) {
+	extrasField = -1
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		tag := f.Tag.Get("marshal")
+		if tag == "-" {
+			continue
+		}
+
+		name := f.Name
+		omitempty := false
+		extras := false
+
+		if tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				switch opt {
+				case "omitempty":
+					omitempty = true
+				case "extras":
+					extras = true
+				}
+			}
+		}
+
+		if extras {
+			extrasField = i
+			continue
+		}
+
+		fields = append(fields, fieldInfo{index: i, name: name, omitempty: omitempty})
+	}
+
+	return
+}
+
+func decodeStruct(entries map[string]*MarshalledObject, rv reflect.Value, path string) error {
+	t := rv.Type()
+	fields, extrasField := structFields(t)
+
+	consumed := make(map[string]bool, len(fields))
+
+	for _, f := range fields {
+		value, ok := entries[f.name]
+		if !ok {
+			continue
+		}
+		consumed[f.name] = true
+
+		if err := decodeValue(value, rv.Field(f.index), fmt.Sprintf("%s[%q]", path, f.name)); err != nil {
+			return err
+		}
+	}
+
+	if extrasField >= 0 {
+		extraType := t.Field(extrasField).Type
+		if extraType.Kind() != reflect.Map || extraType.Key().Kind() != reflect.String {
+			return &UnmarshalTypeError{GoType: extraType, Path: path, Err: fmt.Errorf("extras field must be a map[string]T")}
+		}
+
+		out := reflect.MakeMap(extraType)
+		for k, v := range entries {
+			if consumed[k] {
+				continue
+			}
+
+			elemValue := reflect.New(extraType.Elem()).Elem()
+			if err := decodeValue(v, elemValue, fmt.Sprintf("%s[%q]", path, k)); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k), elemValue)
+		}
+		rv.Field(extrasField).Set(out)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package marshal
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestUnmarshalScalars(t *testing.T) {
+	var b bool
+	if err := Unmarshal(fixtureTrue, &b); err != nil || !b {
+		t.Errorf("Unmarshal(bool) = %v, %v; want true, nil", b, err)
+	}
+
+	var n int
+	if err := Unmarshal(fixtureInt1000, &n); err != nil || n != 1000 {
+		t.Errorf("Unmarshal(int) = %v, %v; want 1000, nil", n, err)
+	}
+
+	var f float64
+	if err := Unmarshal(fixtureFloat, &f); err != nil || f != 1.5 {
+		t.Errorf("Unmarshal(float64) = %v, %v; want 1.5, nil", f, err)
+	}
+
+	var s string
+	if err := Unmarshal(fixtureString, &s); err != nil || s != "foo" {
+		t.Errorf("Unmarshal(string) = %q, %v; want \"foo\", nil", s, err)
+	}
+}
+
+func TestUnmarshalArrayAndMap(t *testing.T) {
+	var arr []int
+	if err := Unmarshal(fixtureArray, &arr); err != nil {
+		t.Fatalf("Unmarshal(array) error = %v", err)
+	}
+	if len(arr) != 3 || arr[0] != 1 || arr[1] != 2 || arr[2] != 3 {
+		t.Errorf("Unmarshal(array) = %v, want [1 2 3]", arr)
+	}
+
+	var m map[string]int
+	if err := Unmarshal(fixtureHash, &m); err != nil {
+		t.Fatalf("Unmarshal(map) error = %v", err)
+	}
+	if m["a"] != 1 {
+		t.Errorf("Unmarshal(map)[\"a\"] = %d, want 1", m["a"])
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	var v interface{}
+	if err := Unmarshal(fixtureArray, &v); err != nil {
+		t.Fatalf("Unmarshal(interface{}) error = %v", err)
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) != 3 {
+		t.Fatalf("Unmarshal(interface{}) = %#v, want []interface{} of length 3", v)
+	}
+}
+
+func TestUnmarshalInterfaceExtraTypes(t *testing.T) {
+	// A Bignum ('l') encoding of 32768: one 2-byte word, little-endian
+	// [0x00, 0x80].
+	var bignum interface{}
+	if err := Unmarshal([]byte{4, 8, 'l', '+', 6, 0, 0x80}, &bignum); err != nil {
+		t.Fatalf("Unmarshal(Bignum) error = %v", err)
+	}
+	if n, ok := bignum.(*big.Int); !ok || n.Cmp(big.NewInt(32768)) != 0 {
+		t.Errorf("Unmarshal(Bignum) = %#v, want *big.Int(32768)", bignum)
+	}
+
+	// A bare Regexp ('/') with source "abc" and no option flags.
+	var re interface{}
+	if err := Unmarshal([]byte{4, 8, '/', 8, 'a', 'b', 'c', 0}, &re); err != nil {
+		t.Fatalf("Unmarshal(Regexp) error = %v", err)
+	}
+	if got, ok := re.(Regexp); !ok || got.Source != "abc" || got.Flags != 0 {
+		t.Errorf("Unmarshal(Regexp) = %#v, want Regexp{\"abc\", 0}", re)
+	}
+
+	// An object dumped via a custom _dump method: class name "Foo",
+	// 2-byte opaque payload "hi".
+	var ud interface{}
+	if err := Unmarshal([]byte{4, 8, 'u', ':', 8, 'F', 'o', 'o', 7, 'h', 'i'}, &ud); err != nil {
+		t.Fatalf("Unmarshal(UserDefined) error = %v", err)
+	}
+	if got, ok := ud.(UserDefined); !ok || got.ClassName != "Foo" || string(got.Payload) != "hi" {
+		t.Errorf("Unmarshal(UserDefined) = %#v, want UserDefined{\"Foo\", \"hi\"}", ud)
+	}
+
+	// Bare class and module references ('c'/'m') named "Obj" and "Mod".
+	var class interface{}
+	if err := Unmarshal([]byte{4, 8, 'c', 8, 'O', 'b', 'j'}, &class); err != nil {
+		t.Fatalf("Unmarshal(Class) error = %v", err)
+	}
+	if got, ok := class.(Class); !ok || got != "Obj" {
+		t.Errorf("Unmarshal(Class) = %#v, want Class(\"Obj\")", class)
+	}
+
+	var module interface{}
+	if err := Unmarshal([]byte{4, 8, 'm', 8, 'M', 'o', 'd'}, &module); err != nil {
+		t.Fatalf("Unmarshal(Module) error = %v", err)
+	}
+	if got, ok := module.(Module); !ok || got != "Mod" {
+		t.Errorf("Unmarshal(Module) = %#v, want Module(\"Mod\")", module)
+	}
+}
+
+func TestUnmarshalRejectsTruncatedInput(t *testing.T) {
+	for _, data := range [][]byte{nil, {}, {4}} {
+		var v interface{}
+		if err := Unmarshal(data, &v); err != IncompleteData {
+			t.Errorf("Unmarshal(%v) error = %v, want IncompleteData", data, err)
+		}
+	}
+}
+
+func TestUnmarshalRequiresNonNilPointer(t *testing.T) {
+	if err := Unmarshal(fixtureNil, 42); err == nil {
+		t.Error("Unmarshal(non-pointer) error = nil, want an error")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var n int
+	dec := NewDecoder(bytes.NewReader(fixtureInt1000))
+	if err := dec.Decode(&n); err != nil || n != 1000 {
+		t.Errorf("Decoder.Decode(int) = %v, %v; want 1000, nil", n, err)
+	}
+}